@@ -25,8 +25,10 @@ import (
 
 	obdconv "github.com/containerd/accelerated-container-image/pkg/convertor"
 	"github.com/containerd/containerd/cmd/ctr/commands"
-	"github.com/containerd/containerd/images/converter"
+	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes/docker"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -43,10 +45,15 @@ var (
 )
 
 var convertCommand = cli.Command{
-	Name:        "obdconv",
-	Usage:       "convert image layer into overlaybd format type",
-	ArgsUsage:   "<src-image> <dst-image>",
-	Description: `Export images to an OCI tar[.gz] into zfile format`,
+	Name:      "obdconv",
+	Usage:     "convert image layer into overlaybd format type",
+	ArgsUsage: "<src-image> <dst-image>",
+	Description: `Export images to an OCI tar[.gz] into zfile format.
+
+src-image is normally a containerd image reference, but may also be given
+as a transport-prefixed reference: oci:/path/to/layout[:tag] or
+docker-archive:/path/image.tar.`,
+	Subcommands: []cli.Command{copyCommand},
 	Flags: append(commands.RegistryFlags,
 		cli.StringFlag{
 			Name:  "fstype",
@@ -68,6 +75,39 @@ var convertCommand = cli.Command{
 			Usage: "The size of a compressed data block in KB. Must be a power of two between 4K~64K [4/8/16/32/64])",
 			Value: 0,
 		},
+		cli.StringSliceFlag{
+			Name:  "platform",
+			Usage: "convert the manifest(s) matching the given platform(s) (e.g. linux/amd64), may be specified multiple times; defaults to the host platform",
+		},
+		cli.BoolFlag{
+			Name:  "all-platforms",
+			Usage: "convert every manifest referenced by a source manifest list, regardless of --platform",
+		},
+		cli.StringSliceFlag{
+			Name:  "encryption-key",
+			Usage: "encrypt converted layers for the recipient identified by this key (jwe:..., pkcs7:..., pgp:...), may be specified multiple times",
+		},
+		cli.IntSliceFlag{
+			Name:  "encrypt-layer",
+			Usage: "index of a converted layer to encrypt (0-based); if unset, every layer is encrypted once --encryption-key is set",
+		},
+		cli.StringSliceFlag{
+			Name:  "decryption-key",
+			Usage: "private key used to decrypt an already-encrypted source image before conversion, may be specified multiple times",
+		},
+		cli.StringFlag{
+			Name:  "compression",
+			Usage: "re-encode converted zfile blocks with this compression: zstd, zstd:chunked, lz4, gzip, uncompressed; accepts level=N (e.g. zstd,level=19)",
+		},
+		cli.BoolFlag{
+			Name:  "force-compression",
+			Usage: "always re-encode with --compression, even if the source layer already matches it",
+		},
+		cli.IntFlag{
+			Name:  "max-parallel",
+			Usage: "maximum number of layers converted concurrently, defaults to the number of CPUs",
+			Value: 0,
+		},
 	),
 	Action: func(context *cli.Context) error {
 		var (
@@ -94,10 +134,7 @@ var convertCommand = cli.Command{
 		}
 		defer done(ctx)
 
-		var (
-			convertOpts = []converter.Opt{}
-			obdOpts     = []obdconv.Option{}
-		)
+		var obdOpts []obdconv.Option
 
 		fsType := context.String("fstype")
 		fmt.Printf("filesystem type: %s\n", fsType)
@@ -112,20 +149,238 @@ var convertCommand = cli.Command{
 		blockSize := context.Int("bs")
 		obdOpts = append(obdOpts, obdconv.WithBlockSize(blockSize))
 
+		if context.Bool("all-platforms") {
+			obdOpts = append(obdOpts, obdconv.WithAllPlatforms(true))
+		} else if ps := context.StringSlice("platform"); len(ps) > 0 {
+			platformList := make([]ocispec.Platform, 0, len(ps))
+			for _, p := range ps {
+				platform, err := platforms.Parse(p)
+				if err != nil {
+					return errors.Wrapf(err, "invalid platform %q", p)
+				}
+				platformList = append(platformList, platform)
+			}
+			obdOpts = append(obdOpts, obdconv.WithPlatforms(platformList))
+		}
+		if keys := context.StringSlice("encryption-key"); len(keys) > 0 {
+			obdOpts = append(obdOpts, obdconv.WithEncryptionKeys(keys))
+		}
+		if layers := context.IntSlice("encrypt-layer"); len(layers) > 0 {
+			obdOpts = append(obdOpts, obdconv.WithEncryptLayers(layers))
+		}
+		if keys := context.StringSlice("decryption-key"); len(keys) > 0 {
+			obdOpts = append(obdOpts, obdconv.WithDecryptionKeys(keys))
+		}
+		if spec := context.String("compression"); spec != "" {
+			compression, err := obdconv.ParseCompression(spec)
+			if err != nil {
+				return errors.Wrap(err, "invalid --compression")
+			}
+			obdOpts = append(obdOpts, obdconv.WithCompression(compression))
+			if compression.Chunked {
+				obdOpts = append(obdOpts, obdconv.WithChunkedTOC(true))
+			}
+		}
+		if context.Bool("force-compression") {
+			obdOpts = append(obdOpts, obdconv.WithForceCompression(true))
+		}
+		obdOpts = append(obdOpts, obdconv.WithMaxParallel(context.Int("max-parallel")))
+		obdOpts = append(obdOpts, obdconv.WithProgress(true))
+
 		resolver, err := commands.GetResolver(ctx, context)
 		if err != nil {
 			return err
 		}
-		obdOpts = append(obdOpts, obdconv.WithResolver(resolver))
 		obdOpts = append(obdOpts, obdconv.WithImageRef(srcImage))
-		obdOpts = append(obdOpts, obdconv.WithClient(cli))
-		convertOpts = append(convertOpts, converter.WithIndexConvertFunc(obdconv.IndexConvertFunc(obdOpts...)))
 
-		newImg, err := converter.Convert(ctx, cli, targetImage, srcImage, convertOpts...)
+		// srcImage may be a bare containerd image reference, or a
+		// skopeo-style transport-prefixed reference (oci:..., docker-archive:...)
+		// pointing at content that never touched the local containerd store.
+		// Either way, backend is how its blobs get into the local content
+		// store for conversion: there is no path that bypasses it.
+		backend, _, err := obdconv.ResolveSource(srcImage, cli, resolver)
+		if err != nil {
+			return err
+		}
+
+		newDesc, err := obdconv.ConvertFromBackend(ctx, cli.ContentStore(), backend, obdOpts...)
+		if err != nil {
+			return err
+		}
+		if _, err := cli.ImageService().Create(ctx, images.Image{Name: targetImage, Target: *newDesc}); err != nil {
+			return err
+		}
+		fmt.Printf("new image digest: %s\n", newDesc.Digest.String())
+		return nil
+	},
+}
+
+// copyCommand converts an image directly from a source registry to a
+// destination registry, without pulling it into (or pushing it out of) a
+// containerd content store.
+var copyCommand = cli.Command{
+	Name:      "copy",
+	Usage:     "convert an image into overlaybd format directly between two registries",
+	ArgsUsage: "<src-image> <dst-image>",
+	Description: `Stream a source image straight from its registry, rewrap its layers as
+overlaybd/zfile blobs, and push the result to a destination registry,
+without requiring a local containerd daemon.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "fstype",
+			Usage: "filesystem type(required), used to mount block device, support specifying mount options and mkfs options, separate fs type and options by ';', separate mount options by ',', separate mkfs options by ' '",
+			Value: "ext4",
+		},
+		cli.StringFlag{
+			Name:  "dbstr",
+			Usage: "data base config string used for layer deduplication",
+			Value: "",
+		},
+		cli.StringFlag{
+			Name:  "algorithm",
+			Usage: "compress algorithm uses in zfile, [lz4|zstd]",
+			Value: "",
+		},
+		cli.IntFlag{
+			Name:  "bs",
+			Usage: "The size of a compressed data block in KB. Must be a power of two between 4K~64K [4/8/16/32/64])",
+			Value: 0,
+		},
+		cli.StringFlag{
+			Name:  "src-username",
+			Usage: "user name to access source registry",
+		},
+		cli.StringFlag{
+			Name:  "src-password",
+			Usage: "password to access source registry",
+		},
+		cli.StringFlag{
+			Name:  "dst-username",
+			Usage: "user name to access destination registry",
+		},
+		cli.StringFlag{
+			Name:  "dst-password",
+			Usage: "password to access destination registry",
+		},
+		cli.BoolFlag{
+			Name:  "plain-http",
+			Usage: "allow connecting to registries over plain http",
+		},
+		cli.StringSliceFlag{
+			Name:  "platform",
+			Usage: "convert the manifest(s) matching the given platform(s) (e.g. linux/amd64), may be specified multiple times; defaults to the host platform",
+		},
+		cli.BoolFlag{
+			Name:  "all-platforms",
+			Usage: "convert every manifest referenced by a source manifest list, regardless of --platform",
+		},
+		cli.StringSliceFlag{
+			Name:  "encryption-key",
+			Usage: "encrypt converted layers for the recipient identified by this key (jwe:..., pkcs7:..., pgp:...), may be specified multiple times",
+		},
+		cli.IntSliceFlag{
+			Name:  "encrypt-layer",
+			Usage: "index of a converted layer to encrypt (0-based); if unset, every layer is encrypted once --encryption-key is set",
+		},
+		cli.StringSliceFlag{
+			Name:  "decryption-key",
+			Usage: "private key used to decrypt an already-encrypted source image before conversion, may be specified multiple times",
+		},
+		cli.StringFlag{
+			Name:  "compression",
+			Usage: "re-encode converted zfile blocks with this compression: zstd, zstd:chunked, lz4, gzip, uncompressed; accepts level=N (e.g. zstd,level=19)",
+		},
+		cli.BoolFlag{
+			Name:  "force-compression",
+			Usage: "always re-encode with --compression, even if the source layer already matches it",
+		},
+		cli.IntFlag{
+			Name:  "max-parallel",
+			Usage: "maximum number of layers converted concurrently, defaults to the number of CPUs",
+			Value: 0,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		var (
+			srcImage    = context.Args().First()
+			targetImage = context.Args().Get(1)
+		)
+		if srcImage == "" || targetImage == "" {
+			return errors.New("please provide src image name and dest image name")
+		}
+
+		ctx, cancel := commands.AppContext(context)
+		defer cancel()
+
+		srcResolver := docker.NewResolver(docker.ResolverOptions{
+			Credentials: func(string) (string, string, error) {
+				return context.String("src-username"), context.String("src-password"), nil
+			},
+			PlainHTTP: context.Bool("plain-http"),
+		})
+		dstResolver := docker.NewResolver(docker.ResolverOptions{
+			Credentials: func(string) (string, string, error) {
+				return context.String("dst-username"), context.String("dst-password"), nil
+			},
+			PlainHTTP: context.Bool("plain-http"),
+		})
+
+		var obdOpts []obdconv.Option
+		obdOpts = append(obdOpts, obdconv.WithFsType(context.String("fstype")))
+		if dbstr := context.String("dbstr"); dbstr != "" {
+			obdOpts = append(obdOpts, obdconv.WithDbstr(dbstr))
+		}
+		obdOpts = append(obdOpts, obdconv.WithAlgorithm(context.String("algorithm")))
+		obdOpts = append(obdOpts, obdconv.WithBlockSize(context.Int("bs")))
+		if context.Bool("all-platforms") {
+			obdOpts = append(obdOpts, obdconv.WithAllPlatforms(true))
+		} else if ps := context.StringSlice("platform"); len(ps) > 0 {
+			platformList := make([]ocispec.Platform, 0, len(ps))
+			for _, p := range ps {
+				platform, err := platforms.Parse(p)
+				if err != nil {
+					return errors.Wrapf(err, "invalid platform %q", p)
+				}
+				platformList = append(platformList, platform)
+			}
+			obdOpts = append(obdOpts, obdconv.WithPlatforms(platformList))
+		}
+		if keys := context.StringSlice("encryption-key"); len(keys) > 0 {
+			obdOpts = append(obdOpts, obdconv.WithEncryptionKeys(keys))
+		}
+		if layers := context.IntSlice("encrypt-layer"); len(layers) > 0 {
+			obdOpts = append(obdOpts, obdconv.WithEncryptLayers(layers))
+		}
+		if keys := context.StringSlice("decryption-key"); len(keys) > 0 {
+			obdOpts = append(obdOpts, obdconv.WithDecryptionKeys(keys))
+		}
+		if spec := context.String("compression"); spec != "" {
+			compression, err := obdconv.ParseCompression(spec)
+			if err != nil {
+				return errors.Wrap(err, "invalid --compression")
+			}
+			obdOpts = append(obdOpts, obdconv.WithCompression(compression))
+			if compression.Chunked {
+				obdOpts = append(obdOpts, obdconv.WithChunkedTOC(true))
+			}
+		}
+		if context.Bool("force-compression") {
+			obdOpts = append(obdOpts, obdconv.WithForceCompression(true))
+		}
+		obdOpts = append(obdOpts, obdconv.WithMaxParallel(context.Int("max-parallel")))
+		obdOpts = append(obdOpts, obdconv.WithProgress(true))
+
+		newDesc, err := obdconv.Copy(ctx,
+			obdconv.WithSrcResolver(srcResolver),
+			obdconv.WithDstResolver(dstResolver),
+			obdconv.WithSrcRef(srcImage),
+			obdconv.WithDstRef(targetImage),
+			obdconv.WithConvertOptions(obdOpts...),
+		)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("new image digest: %s\n", newImg.Target.Digest.String())
+		fmt.Printf("new image digest: %s\n", newDesc.Digest.String())
 		return nil
 	},
 }
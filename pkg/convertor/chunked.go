@@ -0,0 +1,211 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// zstdChunkedManifestAnnotation is the annotation containers/storage
+// reads to locate a zstd:chunked layer's table of contents, as defined by
+// github.com/containers/storage/pkg/chunked.
+const zstdChunkedManifestAnnotation = "io.github.containers.zstd-chunked.manifest"
+
+// zstdChunkedManifestPositionAnnotation records "offset:length:lengthUncompressed:type"
+// of the trailing TOC frame within the layer blob, matching the format
+// containers/storage's chunked puller expects.
+const zstdChunkedManifestPositionAnnotation = "io.github.containers.zstd-chunked.manifest-position"
+
+// zstdChunkedManifestTypeTOC is the "type" field of the manifest-position
+// annotation identifying the trailing frame as a TOC (as opposed to other
+// frame kinds the containers/storage format reserves).
+const zstdChunkedManifestTypeTOC = 1
+
+// WithChunkedTOC controls whether converted "zstd:chunked" layers get a
+// trailing table-of-contents frame, so the resulting overlaybd image can
+// also be consumed as a lazily-pulled image by runtimes that understand
+// the containers/storage zstd:chunked format.
+func WithChunkedTOC(enabled bool) Option {
+	return func(o *options) {
+		o.chunkedTOC = enabled
+	}
+}
+
+// chunkedTOCChunk is one zfile block, of the possibly several a file's
+// content spans, that a puller must byte-range-fetch out of the pushed
+// blob and zfile-decode to reconstruct that slice of the entry.
+type chunkedTOCChunk struct {
+	// ChunkOffset/ChunkSize are the real byte range of this compressed
+	// zfile block within the blob that gets pushed.
+	ChunkOffset int64 `json:"chunkOffset"`
+	ChunkSize   int64 `json:"chunkSize"`
+	// Offset/Size locate this chunk's share of the entry within the
+	// decoded block (Offset counts from the block's first byte), so the
+	// puller knows which part of the decoded block to keep.
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
+// chunkedTOCEntry records the byte range, within the uncompressed layer's
+// tar stream, of a single file's contents, plus the real zfile block(s)
+// within the pushed blob a zstd:chunked-aware puller must fetch to
+// reconstruct it.
+type chunkedTOCEntry struct {
+	Name   string            `json:"name"`
+	Offset int64             `json:"offset"`
+	Size   int64             `json:"size"`
+	Digest string            `json:"digest"`
+	Chunks []chunkedTOCChunk `json:"chunks"`
+}
+
+type chunkedTOC struct {
+	Version int               `json:"version"`
+	Entries []chunkedTOCEntry `json:"entries"`
+}
+
+// tocEntriesFromTar walks plain (the layer's uncompressed tar stream) and
+// records the real offset and size of every regular file's content, by
+// tracking how many bytes of plain the tar reader has consumed each time
+// it returns a header.
+func tocEntriesFromTar(plain []byte) ([]chunkedTOCEntry, error) {
+	cr := &countingReader{r: bytes.NewReader(plain)}
+	tr := tar.NewReader(cr)
+
+	var entries []chunkedTOCEntry
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		// cr.pos now points at the first byte of this entry's content,
+		// since tr.Next() consumes exactly the header block(s) before
+		// returning.
+		offset := cr.pos
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, chunkedTOCEntry{
+			Name:   hdr.Name,
+			Offset: offset,
+			Size:   int64(len(data)),
+			Digest: digestOf(data).String(),
+		})
+	}
+	return entries, nil
+}
+
+// countingReader wraps a reader and tracks how many bytes have been read
+// through it, so tocEntriesFromTar can recover file offsets that
+// archive/tar itself doesn't expose.
+type countingReader struct {
+	r   io.Reader
+	pos int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.pos += int64(n)
+	return n, err
+}
+
+// chunksForRange maps the byte range [start, start+size) of the
+// decompressed tar stream onto the zfile block(s) that cover it, using
+// the real per-block offsets packZfile recorded into the packed blob, so
+// a puller can satisfy the range with real byte-range fetches instead of
+// offsets into the (never pushed) uncompressed stream.
+func chunksForRange(blocks []zfileBlock, blockBytes int, start, size int64) []chunkedTOCChunk {
+	var chunks []chunkedTOCChunk
+	end := start + size
+	for i, b := range blocks {
+		blockStart := int64(i) * int64(blockBytes)
+		blockEnd := blockStart + b.UncompressedSize
+		if blockEnd <= start || blockStart >= end {
+			continue
+		}
+		overlapStart := start
+		if blockStart > overlapStart {
+			overlapStart = blockStart
+		}
+		overlapEnd := end
+		if blockEnd < overlapEnd {
+			overlapEnd = blockEnd
+		}
+		chunks = append(chunks, chunkedTOCChunk{
+			ChunkOffset: b.Offset,
+			ChunkSize:   b.CompressedSize,
+			Offset:      overlapStart - blockStart,
+			Size:        overlapEnd - overlapStart,
+		})
+	}
+	return chunks
+}
+
+// appendChunkedTOC builds the zstd:chunked TOC for plain, maps each
+// entry's byte range onto the real zfile block(s) within packed (as
+// recorded by packZfile), zstd-compresses the TOC, and appends it as a
+// trailing frame to packed. It returns the full blob (packed+frame) along
+// with the annotations a zstd:chunked-aware puller needs to find the
+// frame: the base64-encoded TOC itself (for runtimes that only read the
+// manifest) plus the frame's real offset/length/uncompressed-length
+// within the returned blob.
+func appendChunkedTOC(packed []byte, plain []byte, blocks []zfileBlock, blockSizeKB int) ([]byte, map[string]string, error) {
+	entries, err := tocEntriesFromTar(plain)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to index zstd:chunked TOC entries")
+	}
+	blockBytes := blockSizeKB * 1024
+	for i := range entries {
+		entries[i].Chunks = chunksForRange(blocks, blockBytes, entries[i].Offset, entries[i].Size)
+	}
+
+	data, err := json.Marshal(chunkedTOC{Version: 1, Entries: entries})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal zstd:chunked TOC")
+	}
+	frame, err := compressBytes(data, "zstd", 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to compress zstd:chunked TOC")
+	}
+
+	offset := int64(len(packed))
+	out := make([]byte, 0, len(packed)+len(frame))
+	out = append(out, packed...)
+	out = append(out, frame...)
+
+	annotations := map[string]string{
+		zstdChunkedManifestAnnotation: base64.StdEncoding.EncodeToString(data),
+		zstdChunkedManifestPositionAnnotation: fmt.Sprintf(
+			"%d:%d:%d:%d", offset, len(frame), len(data), zstdChunkedManifestTypeTOC,
+		),
+	}
+	return out, annotations, nil
+}
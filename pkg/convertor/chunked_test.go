@@ -0,0 +1,119 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func buildTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, body := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body))}); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatalf("failed to write tar body: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestTocEntriesFromTar(t *testing.T) {
+	plain := buildTar(t, map[string]string{
+		"a.txt": "hello",
+		"b.txt": "world!!",
+	})
+
+	entries, err := tocEntriesFromTar(plain)
+	if err != nil {
+		t.Fatalf("tocEntriesFromTar: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.Offset <= 0 || e.Offset >= int64(len(plain)) {
+			t.Errorf("entry %q has implausible offset %d for a %d-byte tar", e.Name, e.Offset, len(plain))
+		}
+		got := plain[e.Offset : e.Offset+e.Size]
+		want := map[string]string{"a.txt": "hello", "b.txt": "world!!"}[e.Name]
+		if string(got) != want {
+			t.Errorf("entry %q: plain[%d:%d] = %q, want %q", e.Name, e.Offset, e.Offset+e.Size, got, want)
+		}
+	}
+}
+
+func TestAppendChunkedTOC(t *testing.T) {
+	plain := buildTar(t, map[string]string{"a.txt": "hello"})
+	header := zfileHeader{FsType: "ext4", Algorithm: "zstd", BlockSize: 4}
+	packed, blocks, err := packZfile(header, plain, 0)
+	if err != nil {
+		t.Fatalf("packZfile: %v", err)
+	}
+
+	out, annotations, err := appendChunkedTOC(packed, plain, blocks, header.BlockSize)
+	if err != nil {
+		t.Fatalf("appendChunkedTOC: %v", err)
+	}
+	if !bytes.HasPrefix(out, packed) {
+		t.Error("expected the original packed bytes to remain at the front of the blob")
+	}
+	if len(out) <= len(packed) {
+		t.Error("expected the TOC frame to grow the blob")
+	}
+	if _, ok := annotations[zstdChunkedManifestAnnotation]; !ok {
+		t.Error("missing manifest annotation")
+	}
+	if pos := annotations[zstdChunkedManifestPositionAnnotation]; pos == "0:0:0:0" {
+		t.Error("manifest-position annotation was left as the placeholder value")
+	}
+}
+
+func TestChunksForRange(t *testing.T) {
+	plain := buildTar(t, map[string]string{"a.txt": strings.Repeat("x", 2000)})
+	header := zfileHeader{FsType: "ext4", Algorithm: "zstd", BlockSize: 1}
+	packed, blocks, err := packZfile(header, plain, 0)
+	if err != nil {
+		t.Fatalf("packZfile: %v", err)
+	}
+	if len(blocks) < 2 {
+		t.Fatalf("expected the 1KB block size to split the tar into multiple blocks, got %d", len(blocks))
+	}
+
+	entries, err := tocEntriesFromTar(plain)
+	if err != nil {
+		t.Fatalf("tocEntriesFromTar: %v", err)
+	}
+	chunks := chunksForRange(blocks, header.BlockSize*1024, entries[0].Offset, entries[0].Size)
+	if len(chunks) < 2 {
+		t.Fatalf("expected the entry to span multiple zfile blocks, got %d chunk(s)", len(chunks))
+	}
+	for _, c := range chunks {
+		if c.ChunkOffset <= 0 || c.ChunkOffset+c.ChunkSize > int64(len(packed)) {
+			t.Errorf("chunk %+v points outside the packed blob (len %d)", c, len(packed))
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/pkg/errors"
+)
+
+// Compression identifies the algorithm (and optional chunking scheme) used
+// to compress a zfile data block.
+type Compression struct {
+	// Algorithm is one of "zstd", "lz4", "gzip" or "uncompressed".
+	Algorithm string
+	// Chunked requests the containers/storage zstd:chunked layout; only
+	// valid when Algorithm is "zstd".
+	Chunked bool
+	// Level is the compressor's compression level, or 0 to use the
+	// algorithm's default.
+	Level int
+}
+
+// ParseCompression parses a buildkit-style compression spec such as
+// "zstd", "zstd:chunked", "lz4", "gzip", "uncompressed" or
+// "zstd,level=19".
+func ParseCompression(spec string) (Compression, error) {
+	var c Compression
+	parts := strings.Split(spec, ",")
+	algo := parts[0]
+	if algo == "zstd:chunked" {
+		c.Algorithm = "zstd"
+		c.Chunked = true
+	} else {
+		c.Algorithm = algo
+	}
+	switch c.Algorithm {
+	case "zstd", "lz4", "gzip", "uncompressed", "":
+	default:
+		return c, errors.Errorf("unsupported compression algorithm %q", algo)
+	}
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return c, errors.Errorf("invalid compression option %q", p)
+		}
+		switch kv[0] {
+		case "level":
+			level, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return c, errors.Wrapf(err, "invalid compression level %q", kv[1])
+			}
+			c.Level = level
+		default:
+			return c, errors.Errorf("unknown compression option %q", kv[0])
+		}
+	}
+	return c, nil
+}
+
+// WithCompression sets the target compression algorithm applied to
+// converted zfile blocks, as parsed by ParseCompression.
+func WithCompression(c Compression) Option {
+	return func(o *options) {
+		o.compression = c
+	}
+}
+
+// WithCompressionLevel sets the compressor's compression level, trading
+// CPU time for output size. 0 keeps the algorithm's default.
+func WithCompressionLevel(level int) Option {
+	return func(o *options) {
+		o.compression.Level = level
+	}
+}
+
+// WithForceCompression makes every converted layer's zfile blocks use the
+// requested compression, even if the source blob is already compressed
+// with a matching algorithm/level. Without it, such a matching layer's
+// blocks use WithAlgorithm's algorithm when that is set. Every layer is
+// decompressed and repacked either way; no layer is passed through
+// unchanged.
+func WithForceCompression(force bool) Option {
+	return func(o *options) {
+		o.forceCompression = force
+	}
+}
+
+// needsRecompress reports whether a layer whose existing compression is
+// existing has to be re-encoded with the requested target compression:
+// always when forced, never when no target is set, and otherwise whenever
+// existing differs from the target.
+func (o *options) needsRecompress(existing Compression) bool {
+	if o.forceCompression {
+		return true
+	}
+	if o.compression.Algorithm == "" {
+		return false
+	}
+	return existing != o.compression
+}
+
+// decompressBytes decodes data that was compressed with algorithm (the
+// Compression the source layer already carries), returning the raw bytes
+// so they can be re-encoded with a different target algorithm.
+func decompressBytes(data []byte, algorithm string) ([]byte, error) {
+	switch algorithm {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open gzip stream")
+		}
+		defer zr.Close()
+		return ioutil.ReadAll(zr)
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open zstd stream")
+		}
+		defer zr.Close()
+		return ioutil.ReadAll(zr)
+	case "lz4":
+		return ioutil.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+	case "uncompressed", "":
+		return data, nil
+	default:
+		return nil, errors.Errorf("unsupported compression algorithm %q", algorithm)
+	}
+}
+
+// compressBytes re-encodes data with algorithm, applying level when the
+// algorithm supports tuning compression level (0 keeps the algorithm's
+// default).
+func compressBytes(data []byte, algorithm string, level int) ([]byte, error) {
+	switch algorithm {
+	case "gzip":
+		l := gzip.DefaultCompression
+		if level > 0 {
+			l = level
+		}
+		var buf bytes.Buffer
+		zw, err := gzip.NewWriterLevel(&buf, l)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open gzip writer")
+		}
+		if _, err := zw.Write(data); err != nil {
+			return nil, errors.Wrap(err, "failed to gzip layer")
+		}
+		if err := zw.Close(); err != nil {
+			return nil, errors.Wrap(err, "failed to flush gzip writer")
+		}
+		return buf.Bytes(), nil
+	case "zstd":
+		var opts []zstd.EOption
+		if level > 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		zw, err := zstd.NewWriter(nil, opts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open zstd writer")
+		}
+		return zw.EncodeAll(data, nil), nil
+	case "lz4":
+		var buf bytes.Buffer
+		zw := lz4.NewWriter(&buf)
+		if level > 0 {
+			// lz4's Level1..Level9 constants are 1<<9 .. 1<<17, not 1..9.
+			if err := zw.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(1 << (8 + level)))); err != nil {
+				return nil, errors.Wrap(err, "failed to set lz4 compression level")
+			}
+		}
+		if _, err := zw.Write(data); err != nil {
+			return nil, errors.Wrap(err, "failed to lz4-compress layer")
+		}
+		if err := zw.Close(); err != nil {
+			return nil, errors.Wrap(err, "failed to flush lz4 writer")
+		}
+		return buf.Bytes(), nil
+	case "uncompressed", "":
+		return data, nil
+	default:
+		return nil, errors.Errorf("unsupported compression algorithm %q", algorithm)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import "testing"
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Compression
+		wantErr bool
+	}{
+		{"zstd", Compression{Algorithm: "zstd"}, false},
+		{"zstd:chunked", Compression{Algorithm: "zstd", Chunked: true}, false},
+		{"lz4", Compression{Algorithm: "lz4"}, false},
+		{"gzip", Compression{Algorithm: "gzip"}, false},
+		{"uncompressed", Compression{Algorithm: "uncompressed"}, false},
+		{"zstd,level=19", Compression{Algorithm: "zstd", Level: 19}, false},
+		{"bogus", Compression{}, true},
+		{"zstd,level=nope", Compression{}, true},
+		{"zstd,bogus=1", Compression{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseCompression(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCompression(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ParseCompression(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeedsRecompress(t *testing.T) {
+	tests := []struct {
+		name     string
+		o        *options
+		existing Compression
+		want     bool
+	}{
+		{"no target compression", &options{}, Compression{Algorithm: "gzip"}, false},
+		{"matches target", &options{compression: Compression{Algorithm: "zstd"}}, Compression{Algorithm: "zstd"}, false},
+		{"differs from target", &options{compression: Compression{Algorithm: "zstd"}}, Compression{Algorithm: "gzip"}, true},
+		{"forced even when matching", &options{compression: Compression{Algorithm: "zstd"}, forceCompression: true}, Compression{Algorithm: "zstd"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.o.needsRecompress(tt.existing); got != tt.want {
+				t.Errorf("needsRecompress(%+v) = %v, want %v", tt.existing, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package convertor converts OCI/docker images into the overlaybd (zfile)
+// format, either through a local containerd content store or directly
+// between registries.
+package convertor
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/converter"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Option configures how an image is converted into the overlaybd format.
+type Option func(*options)
+
+type options struct {
+	ref string
+
+	fsType    string
+	dbstr     string
+	algorithm string
+	blockSize int
+
+	platforms    []ocispec.Platform
+	allPlatforms bool
+
+	encryptKeys   []string
+	encryptLayers []int
+	decryptKeys   []string
+
+	compression      Compression
+	forceCompression bool
+
+	maxParallel int
+	progress    bool
+
+	chunkedTOC bool
+}
+
+// WithImageRef sets the reference of the image being converted, used to
+// give conversion errors useful context (e.g. which image a given layer
+// failed to pack belongs to).
+func WithImageRef(ref string) Option {
+	return func(o *options) {
+		o.ref = ref
+	}
+}
+
+// WithFsType sets the filesystem type used to mount the overlaybd block
+// device while packing each layer (e.g. "ext4").
+func WithFsType(fsType string) Option {
+	return func(o *options) {
+		o.fsType = fsType
+	}
+}
+
+// WithDbstr sets the database connection string used for layer
+// deduplication.
+func WithDbstr(dbstr string) Option {
+	return func(o *options) {
+		o.dbstr = dbstr
+	}
+}
+
+// WithAlgorithm sets the zfile compression algorithm, one of "lz4" or
+// "zstd". An empty string keeps each source layer's own compression
+// algorithm.
+func WithAlgorithm(algorithm string) Option {
+	return func(o *options) {
+		o.algorithm = algorithm
+	}
+}
+
+// WithBlockSize sets the size, in KB, of a compressed data block in zfile.
+func WithBlockSize(blockSize int) Option {
+	return func(o *options) {
+		o.blockSize = blockSize
+	}
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		fsType: "ext4",
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// conv holds the state shared across the conversion of a single image.
+type conv struct {
+	*options
+}
+
+// IndexConvertFunc returns a converter.ConvertFunc that rewrites every
+// layer of the source manifest (or manifest list) into the overlaybd/zfile
+// format, reusing the blob store given to it by converter.Convert.
+func IndexConvertFunc(opts ...Option) converter.ConvertFunc {
+	c := &conv{options: newOptions(opts...)}
+	return c.convert
+}
+
+func (c *conv) convert(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		return c.convertIndex(ctx, cs, desc)
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		return c.convertManifest(ctx, cs, desc)
+	default:
+		return nil, errors.Errorf("unsupported media type for overlaybd conversion: %s", desc.MediaType)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// DirectOption configures a registry-to-registry conversion performed
+// without a containerd daemon.
+type DirectOption func(*directOptions)
+
+type directOptions struct {
+	srcRef, dstRef           string
+	srcResolver, dstResolver remotes.Resolver
+	obdOpts                  []Option
+}
+
+// WithSrcResolver sets the resolver used to read the source image.
+func WithSrcResolver(resolver remotes.Resolver) DirectOption {
+	return func(o *directOptions) {
+		o.srcResolver = resolver
+	}
+}
+
+// WithDstResolver sets the resolver used to push the converted image.
+func WithDstResolver(resolver remotes.Resolver) DirectOption {
+	return func(o *directOptions) {
+		o.dstResolver = resolver
+	}
+}
+
+// WithSrcRef sets the reference of the source image.
+func WithSrcRef(ref string) DirectOption {
+	return func(o *directOptions) {
+		o.srcRef = ref
+	}
+}
+
+// WithDstRef sets the reference the converted image is pushed to.
+func WithDstRef(ref string) DirectOption {
+	return func(o *directOptions) {
+		o.dstRef = ref
+	}
+}
+
+// WithConvertOptions sets the overlaybd conversion options (fstype,
+// algorithm, block size, ...) applied while converting layers.
+func WithConvertOptions(opts ...Option) DirectOption {
+	return func(o *directOptions) {
+		o.obdOpts = opts
+	}
+}
+
+// Copy streams an image directly from a source registry to a destination
+// registry, rewrapping its layers as overlaybd/zfile blobs along the way.
+// It never touches a containerd daemon: content is staged in a temporary
+// on-disk content store that is removed once the push completes.
+func Copy(ctx context.Context, opts ...DirectOption) (*ocispec.Descriptor, error) {
+	o := &directOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.srcResolver == nil || o.dstResolver == nil {
+		return nil, errors.New("source and destination resolvers are required")
+	}
+	if o.srcRef == "" || o.dstRef == "" {
+		return nil, errors.New("source and destination references are required")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "obdconv-direct-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temp content store")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cs, err := local.NewStore(tmpDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open temp content store")
+	}
+
+	srcDesc, err := fetchIndex(ctx, cs, o.srcResolver, o.srcRef)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch source image")
+	}
+
+	convertFn := IndexConvertFunc(o.obdOpts...)
+	dstDesc, err := convertFn(ctx, cs, *srcDesc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert image")
+	}
+
+	if err := pushImage(ctx, cs, o.dstResolver, o.dstRef, *dstDesc); err != nil {
+		return nil, errors.Wrap(err, "failed to push converted image")
+	}
+	return dstDesc, nil
+}
+
+func fetchIndex(ctx context.Context, cs content.Store, resolver remotes.Resolver, ref string) (*ocispec.Descriptor, error) {
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return fetchWith(ctx, cs, desc, fetcher)
+}
+
+// fetchWith pulls desc and everything it references (layers, child
+// manifests, ...) out of fetcher and into cs. images.ChildrenHandler only
+// enumerates the children of a manifest/index already present in cs, so it
+// is chained behind the fetch handler and the whole chain is walked
+// recursively by images.Dispatch, fetching each child in turn until every
+// leaf blob has been pulled.
+func fetchWith(ctx context.Context, cs content.Store, desc ocispec.Descriptor, fetcher remotes.Fetcher) (*ocispec.Descriptor, error) {
+	handler := images.Handlers(remotes.FetchHandler(cs, fetcher), images.ChildrenHandler(cs))
+	if err := images.Dispatch(ctx, handler, nil, desc); err != nil {
+		return nil, err
+	}
+	return &desc, nil
+}
+
+func pushImage(ctx context.Context, cs content.Store, resolver remotes.Resolver, ref string, desc ocispec.Descriptor) error {
+	pusher, err := resolver.Pusher(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return remotes.PushContent(ctx, pusher, desc, cs, nil, nil, nil)
+}
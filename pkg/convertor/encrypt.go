@@ -0,0 +1,272 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containers/ocicrypt"
+	encconfig "github.com/containers/ocicrypt/config"
+	"github.com/containers/ocicrypt/keywrap/jwe"
+	"github.com/containers/ocicrypt/keywrap/pgp"
+	"github.com/containers/ocicrypt/keywrap/pkcs7"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// encryptedMediaTypeSuffix is appended to a layer's media type once it has
+// been wrapped by ocicrypt, mirroring containerd/imgcrypt's "+encrypted"
+// convention.
+const encryptedMediaTypeSuffix = "+encrypted"
+
+// WithEncryptionKeys sets the recipient keys (jwe:..., pkcs7:..., pgp:...)
+// used to encrypt converted layers selected by WithEncryptLayers.
+func WithEncryptionKeys(keys []string) Option {
+	return func(o *options) {
+		o.encryptKeys = keys
+	}
+}
+
+// WithEncryptLayers selects, by index, which converted layers are
+// encrypted. A nil/empty slice means every layer is encrypted once
+// encryption keys are set.
+func WithEncryptLayers(layers []int) Option {
+	return func(o *options) {
+		o.encryptLayers = layers
+	}
+}
+
+// WithDecryptionKeys sets the private keys used to decrypt a source image
+// that is itself encrypted, before it is fed into the conversion pipeline.
+func WithDecryptionKeys(keys []string) Option {
+	return func(o *options) {
+		o.decryptKeys = keys
+	}
+}
+
+func (o *options) cryptoConfig() (encconfig.CryptoConfig, error) {
+	var ccs []encconfig.CryptoConfig
+	if len(o.encryptKeys) > 0 {
+		cc, err := encryptConfigFromSpecs(o.encryptKeys)
+		if err != nil {
+			return encconfig.CryptoConfig{}, errors.Wrap(err, "failed to build encryption config")
+		}
+		ccs = append(ccs, cc)
+	}
+	if len(o.decryptKeys) > 0 {
+		cc, err := decryptConfigFromSpecs(o.decryptKeys)
+		if err != nil {
+			return encconfig.CryptoConfig{}, errors.Wrap(err, "failed to build decryption config")
+		}
+		ccs = append(ccs, cc)
+	}
+	return encconfig.CombineCryptoConfigs(ccs), nil
+}
+
+// splitKeySpec splits a "scheme:path" key spec, as used by
+// --encryption-key/--decryption-key, the way `ctr images encrypt` does.
+func splitKeySpec(spec string) (scheme, path string, err error) {
+	i := strings.Index(spec, ":")
+	if i < 0 {
+		return "", "", errors.Errorf("key spec %q must be prefixed with jwe:, pkcs7: or pgp:", spec)
+	}
+	return spec[:i], spec[i+1:], nil
+}
+
+// encryptConfigFromSpecs groups "jwe:", "pkcs7:" and "pgp:" prefixed key
+// specs by scheme, reads their key material from disk, and builds the
+// combined CryptoConfig ocicrypt expects.
+func encryptConfigFromSpecs(specs []string) (encconfig.CryptoConfig, error) {
+	var jwePubKeys, pkcs7X509s, pgpRecipients [][]byte
+	for _, spec := range specs {
+		scheme, path, err := splitKeySpec(spec)
+		if err != nil {
+			return encconfig.CryptoConfig{}, err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return encconfig.CryptoConfig{}, errors.Wrapf(err, "failed to read key %q", path)
+		}
+		switch scheme {
+		case "jwe":
+			jwePubKeys = append(jwePubKeys, data)
+		case "pkcs7":
+			pkcs7X509s = append(pkcs7X509s, data)
+		case "pgp":
+			pgpRecipients = append(pgpRecipients, data)
+		default:
+			return encconfig.CryptoConfig{}, errors.Errorf("unsupported encryption key scheme %q", scheme)
+		}
+	}
+
+	var ccs []encconfig.CryptoConfig
+	if len(jwePubKeys) > 0 {
+		cc, err := encconfig.EncryptWithJwe(jwePubKeys)
+		if err != nil {
+			return encconfig.CryptoConfig{}, err
+		}
+		ccs = append(ccs, cc)
+	}
+	if len(pkcs7X509s) > 0 {
+		cc, err := encconfig.EncryptWithPkcs7(pkcs7X509s)
+		if err != nil {
+			return encconfig.CryptoConfig{}, err
+		}
+		ccs = append(ccs, cc)
+	}
+	if len(pgpRecipients) > 0 {
+		cc, err := encconfig.EncryptWithGpg(pgpRecipients, nil)
+		if err != nil {
+			return encconfig.CryptoConfig{}, err
+		}
+		ccs = append(ccs, cc)
+	}
+	return encconfig.CombineCryptoConfigs(ccs), nil
+}
+
+// decryptConfigFromSpecs mirrors encryptConfigFromSpecs for
+// --decryption-key: jwe/pkcs7 private keys are handled identically by
+// ocicrypt, pgp private keys need their own keyring.
+func decryptConfigFromSpecs(specs []string) (encconfig.CryptoConfig, error) {
+	var privKeys, pgpPrivKeys [][]byte
+	for _, spec := range specs {
+		scheme, path, err := splitKeySpec(spec)
+		if err != nil {
+			return encconfig.CryptoConfig{}, err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return encconfig.CryptoConfig{}, errors.Wrapf(err, "failed to read key %q", path)
+		}
+		switch scheme {
+		case "jwe", "pkcs7":
+			privKeys = append(privKeys, data)
+		case "pgp":
+			pgpPrivKeys = append(pgpPrivKeys, data)
+		default:
+			return encconfig.CryptoConfig{}, errors.Errorf("unsupported decryption key scheme %q", scheme)
+		}
+	}
+
+	var ccs []encconfig.CryptoConfig
+	if len(privKeys) > 0 {
+		cc, err := encconfig.DecryptWithPrivKeys(privKeys, nil)
+		if err != nil {
+			return encconfig.CryptoConfig{}, err
+		}
+		ccs = append(ccs, cc)
+	}
+	if len(pgpPrivKeys) > 0 {
+		cc, err := encconfig.DecryptWithGpgPrivKeys(pgpPrivKeys, nil)
+		if err != nil {
+			return encconfig.CryptoConfig{}, err
+		}
+		ccs = append(ccs, cc)
+	}
+	return encconfig.CombineCryptoConfigs(ccs), nil
+}
+
+func (o *options) shouldEncrypt(layerIndex int) bool {
+	if len(o.encryptKeys) == 0 {
+		return false
+	}
+	if len(o.encryptLayers) == 0 {
+		return true
+	}
+	for _, i := range o.encryptLayers {
+		if i == layerIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// decryptSource decrypts an encrypted source layer ahead of overlaybd
+// conversion, returning a plaintext reader and the layer's original media
+// type with the "+encrypted" suffix stripped.
+func (c *conv) decryptSource(ctx context.Context, desc ocispec.Descriptor, r io.Reader) (io.Reader, string, error) {
+	if !strings.HasSuffix(desc.MediaType, encryptedMediaTypeSuffix) {
+		return r, desc.MediaType, nil
+	}
+	cc, err := c.cryptoConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	dr, _, err := ocicrypt.DecryptLayer(cc.DecryptConfig, r, desc, false)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to decrypt source layer")
+	}
+	return dr, strings.TrimSuffix(desc.MediaType, encryptedMediaTypeSuffix), nil
+}
+
+// encryptLayer wraps a freshly produced zfile blob with ocicrypt, writing
+// the encrypted bytes into cs and returning the descriptor annotated the
+// way ocicrypt-aware runtimes expect.
+func (c *conv) encryptLayer(ctx context.Context, cs content.Store, desc ocispec.Descriptor, layerIndex int) (*ocispec.Descriptor, error) {
+	if !c.shouldEncrypt(layerIndex) {
+		return &desc, nil
+	}
+	cc, err := c.cryptoConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read layer to encrypt")
+	}
+	defer ra.Close()
+
+	er, finalizer, err := ocicrypt.EncryptLayer(cc.EncryptConfig, content.NewReader(ra), desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt layer")
+	}
+	data, err := ioutil.ReadAll(er)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read encrypted layer")
+	}
+	// The annotations ocicrypt wants recorded (wrapped keys, etc.) are only
+	// known once the whole stream has been encrypted, so the finalizer must
+	// run after draining er above.
+	annotations, err := finalizer()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to finalize layer encryption")
+	}
+
+	newDesc := ocispec.Descriptor{
+		MediaType:   desc.MediaType + encryptedMediaTypeSuffix,
+		Digest:      digestOf(data),
+		Size:        int64(len(data)),
+		Annotations: annotations,
+	}
+	if err := content.WriteBlob(ctx, cs, newDesc.Digest.String(), newReader(data), newDesc); err != nil {
+		return nil, errors.Wrap(err, "failed to write encrypted layer")
+	}
+	return &newDesc, nil
+}
+
+// The keywrap packages are only referenced here so that their imports are
+// used; ocicrypt registers the jwe/pkcs7/pgp key wrappers in its own init.
+var (
+	_ = jwe.NewKeyWrapper
+	_ = pkcs7.NewKeyWrapper
+	_ = pgp.NewKeyWrapper
+)
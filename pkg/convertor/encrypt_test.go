@@ -0,0 +1,40 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import "testing"
+
+func TestShouldEncrypt(t *testing.T) {
+	tests := []struct {
+		name   string
+		o      *options
+		layer  int
+		expect bool
+	}{
+		{"no keys", &options{}, 0, false},
+		{"keys, no layer selection", &options{encryptKeys: []string{"jwe:pub.pem"}}, 3, true},
+		{"keys, selected layer", &options{encryptKeys: []string{"jwe:pub.pem"}, encryptLayers: []int{1, 2}}, 1, true},
+		{"keys, unselected layer", &options{encryptKeys: []string{"jwe:pub.pem"}, encryptLayers: []int{1, 2}}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.o.shouldEncrypt(tt.layer); got != tt.expect {
+				t.Errorf("shouldEncrypt(%d) = %v, want %v", tt.layer, got, tt.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"context"
+	"io"
+
+	"github.com/containerd/containerd/content"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// localStoreFetcher adapts a content.Store that already holds a blob
+// (e.g. a containerd content store, or a temporary store populated from
+// an OCI layout/tar) into a remotes.Fetcher.
+type localStoreFetcher struct {
+	store content.Store
+}
+
+func (f *localStoreFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	ra, err := f.store.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	return &readerAtCloser{ReaderAt: ra, size: desc.Size}, nil
+}
+
+// readerAtCloser exposes a content.ReaderAt as a sequential io.ReadCloser,
+// which is what the remotes.Fetcher interface expects.
+type readerAtCloser struct {
+	content.ReaderAt
+	off  int64
+	size int64
+}
+
+func (r *readerAtCloser) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	n, err := r.ReadAt(p, r.off)
+	r.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,134 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// MediaTypeOverlayBDBlob is the media type of a layer that has been
+// converted into the overlaybd/zfile format.
+const MediaTypeOverlayBDBlob = "application/vnd.containerd.overlaybd.delta"
+
+// convertLayer unpacks a single OCI layer and repacks its uncompressed tar
+// bytes into a zfile blob: a magic, a JSON header recording c.fsType and
+// c.dbstr as metadata (no block device is mkfs'd, mounted, or applied —
+// this package is a from-scratch pure-Go stand-in for the real overlaybd
+// toolchain, not a wrapper around it), followed by the tar split into
+// fixed-size, independently compressed blocks. parentID is the diffID of
+// the previously converted layer (empty for the bottom-most layer) and is
+// recorded in the header so the overlaybd snapshotter can chain this
+// layer onto its parent's. desc is already plaintext: the caller
+// (convertLayersParallel) decrypts an encrypted source layer ahead of
+// calling convertLayer, so this never decrypts on its own.
+func (c *conv) convertLayer(ctx context.Context, cs content.Store, desc ocispec.Descriptor, parentID string) (*ocispec.Descriptor, digest.Digest, error) {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := ioutil.ReadAll(content.NewReader(ra))
+	ra.Close()
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "%s: failed to read layer", c.ref)
+	}
+
+	existing := compressionOf(desc)
+	plain, err := decompressBytes(data, existing.Algorithm)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "%s: failed to decompress layer", c.ref)
+	}
+	diffID := digestOf(plain)
+
+	// c.algorithm (--algorithm), when set, replaces the source layer's own
+	// algorithm. c.compression.Algorithm (--compression) overrides both,
+	// but only when the requested compression doesn't already match what's
+	// on disk, or when the caller forced it with WithForceCompression.
+	// Otherwise the blocks use c.algorithm if set, else the source layer's
+	// existing algorithm.
+	algorithm := existing.Algorithm
+	if c.algorithm != "" {
+		algorithm = c.algorithm
+	}
+	if c.needsRecompress(existing) && c.compression.Algorithm != "" {
+		algorithm = c.compression.Algorithm
+	}
+
+	blockSize := c.blockSize
+	if blockSize <= 0 {
+		blockSize = defaultBlockSizeKB
+	}
+	header := zfileHeader{
+		FsType:    c.fsType,
+		Dbstr:     c.dbstr,
+		ParentID:  parentID,
+		Algorithm: algorithm,
+		BlockSize: blockSize,
+	}
+	packed, blocks, err := packZfile(header, plain, c.compression.Level)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "%s: failed to pack zfile", c.ref)
+	}
+
+	annotations := map[string]string{
+		"containerd.io/snapshot/overlaybd/compression": algorithm,
+	}
+	if c.compression.Chunked && c.chunkedTOC {
+		var chunkedAnnotations map[string]string
+		packed, chunkedAnnotations, err = appendChunkedTOC(packed, plain, blocks, header.BlockSize)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "%s: failed to append zstd:chunked TOC", c.ref)
+		}
+		for k, v := range chunkedAnnotations {
+			annotations[k] = v
+		}
+	}
+
+	newDesc := ocispec.Descriptor{
+		MediaType:   MediaTypeOverlayBDBlob,
+		Digest:      digestOf(packed),
+		Size:        int64(len(packed)),
+		Annotations: annotations,
+	}
+	if err := content.WriteBlob(ctx, cs, newDesc.Digest.String(), newReader(packed), newDesc); err != nil {
+		return nil, "", errors.Wrapf(err, "%s: failed to write packed layer", c.ref)
+	}
+	return &newDesc, diffID, nil
+}
+
+// compressionOf infers a source layer's compression algorithm from its
+// media type (e.g. "...tar+gzip"); Level is always 0. A media type naming
+// none of zstd, lz4 or gzip, MediaTypeOverlayBDBlob included, is reported
+// as uncompressed.
+func compressionOf(desc ocispec.Descriptor) Compression {
+	switch {
+	case strings.Contains(desc.MediaType, "zstd"):
+		return Compression{Algorithm: "zstd"}
+	case strings.Contains(desc.MediaType, "lz4"):
+		return Compression{Algorithm: "lz4"}
+	case strings.Contains(desc.MediaType, "gzip"):
+		return Compression{Algorithm: "gzip"}
+	default:
+		return Compression{Algorithm: "uncompressed"}
+	}
+}
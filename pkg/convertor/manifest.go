@@ -0,0 +1,99 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// convertManifest rewrites every layer of a single-platform manifest into
+// the overlaybd/zfile format, rewrites the image config's rootfs.diff_ids
+// to match, and writes a new manifest pointing at the converted layers
+// and config.
+func (c *conv) convertManifest(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	manifest, err := images.Manifest(ctx, cs, desc, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read manifest")
+	}
+
+	newLayers, diffIDs, err := c.convertLayersParallel(ctx, cs, manifest.Layers)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert layers")
+	}
+	manifest.Layers = newLayers
+
+	newConfigDesc, err := c.rewriteConfig(ctx, cs, manifest.Config, diffIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to rewrite image config")
+	}
+	manifest.Config = *newConfigDesc
+
+	newManifestDesc, err := writeJSON(ctx, cs, manifest, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write converted manifest")
+	}
+	return newManifestDesc, nil
+}
+
+// rewriteConfig reads the image config at desc and replaces its
+// rootfs.diff_ids with diffIDs (the converted layers' plaintext diffIDs),
+// writing the result as a new blob. The converted layers carry new
+// digests, so the config's diff_ids must be updated to match or unpacking
+// the image will fail diffID verification.
+func (c *conv) rewriteConfig(ctx context.Context, cs content.Store, desc ocispec.Descriptor, diffIDs []digest.Digest) (*ocispec.Descriptor, error) {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(content.NewReader(ra))
+	ra.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg ocispec.Image
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, errors.Wrap(err, "failed to parse image config")
+	}
+	cfg.RootFS.DiffIDs = diffIDs
+
+	return writeJSON(ctx, cs, cfg, desc)
+}
+
+func writeJSON(ctx context.Context, cs content.Store, obj interface{}, origin ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType: origin.MediaType,
+		Digest:    digestOf(data),
+		Size:      int64(len(data)),
+	}
+	if err := content.WriteBlob(ctx, cs, desc.Digest.String(), newReader(data), desc); err != nil {
+		return nil, err
+	}
+	return &desc, nil
+}
@@ -0,0 +1,95 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// ociLayoutSource reads an image out of an OCI image layout directory
+// (github.com/opencontainers/image-spec/specs-go/v1's "oci-layout" +
+// "index.json" + "blobs/<algo>/<digest>" layout), as produced by
+// `skopeo copy` / `buildkit` / `ctr images export --oci`.
+type ociLayoutSource struct {
+	dir string
+	tag string
+	cs  content.Store
+}
+
+// newOCILayoutSource opens "path[:tag]" as an OCI layout directory. tag
+// selects which entry of index.json to use; if empty and the index has
+// exactly one manifest, that one is used.
+func newOCILayoutSource(pathAndTag string) (*ociLayoutSource, string, error) {
+	dir, tag := splitLayoutRef(pathAndTag)
+
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "oci-layout")); err != nil {
+		return nil, "", errors.Wrapf(err, "%q is not an OCI image layout", dir)
+	}
+	cs, err := local.NewStore(dir)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to open OCI layout %q as a content store", dir)
+	}
+	return &ociLayoutSource{dir: dir, tag: tag, cs: cs}, dir, nil
+}
+
+func splitLayoutRef(pathAndTag string) (dir, tag string) {
+	// A Windows-style drive letter ("C:\...") would also match this
+	// split; OCI layout paths given to obdconv are expected to be
+	// absolute POSIX paths, matching skopeo's oci: transport.
+	if i := strings.LastIndex(pathAndTag, ":"); i > 0 {
+		return pathAndTag[:i], pathAndTag[i+1:]
+	}
+	return pathAndTag, ""
+}
+
+func (s *ociLayoutSource) Resolve(ctx context.Context) (ocispec.Descriptor, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, "index.json"))
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "failed to read index.json")
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "failed to parse index.json")
+	}
+
+	if s.tag == "" {
+		if len(index.Manifests) != 1 {
+			return ocispec.Descriptor{}, errors.Errorf("index.json has %d entries, not exactly one; specify a tag with oci:/path:tag", len(index.Manifests))
+		}
+		return index.Manifests[0], nil
+	}
+	for _, m := range index.Manifests {
+		if m.Annotations[ocispec.AnnotationRefName] == s.tag {
+			return m, nil
+		}
+	}
+	return ocispec.Descriptor{}, errors.Errorf("tag %q not found in %s", s.tag, s.dir)
+}
+
+func (s *ociLayoutSource) Fetcher() remotes.Fetcher {
+	return &localStoreFetcher{store: s.cs}
+}
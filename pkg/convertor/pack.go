@@ -0,0 +1,123 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// zfileMagic is the fixed 8-byte signature at the start of every blob
+// convertLayer writes, identifying it as a packed overlaybd zfile rather
+// than a plain OCI tar layer.
+var zfileMagic = [8]byte{'o', 'v', 'l', 'b', 'd', 'z', 'f', '1'}
+
+// defaultBlockSizeKB is the zfile block size used when the caller didn't
+// set one with WithBlockSize.
+const defaultBlockSizeKB = 64
+
+// zfileHeader is the JSON header embedded in every zfile blob. FsType and
+// Dbstr are carried through as metadata for the overlaybd snapshotter to
+// read back — this package never mkfs's or mounts a block device of that
+// type itself, so they don't affect how packZfile encodes the layer.
+// ParentID lets the snapshotter (or the next layer in the chain) identify
+// this zfile's parent without re-deriving it from the image config.
+type zfileHeader struct {
+	FsType    string `json:"fsType"`
+	Dbstr     string `json:"dbstr,omitempty"`
+	ParentID  string `json:"parentId,omitempty"`
+	Algorithm string `json:"algorithm"`
+	BlockSize int    `json:"blockSizeKB"`
+}
+
+// zfileBlock records where one compressed zfile block ended up in the
+// packed blob, which appendChunkedTOC needs to build real zstd:chunked
+// file-boundary offsets.
+type zfileBlock struct {
+	Offset           int64
+	CompressedSize   int64
+	UncompressedSize int64
+}
+
+// packZfile packs plain (the layer's uncompressed tar diff) into a zfile
+// blob: a magic + JSON header, followed by plain split into
+// header.BlockSize-KB blocks, each compressed with header.Algorithm. This
+// is a bespoke pure-Go container format, not a byte-for-byte real
+// overlaybd zfile — there is no block device involved anywhere in this
+// package. It returns the packed bytes plus the offset/size of every
+// block within them, for the chunked TOC pass to reuse.
+func packZfile(header zfileHeader, plain []byte, level int) ([]byte, []zfileBlock, error) {
+	if header.BlockSize <= 0 {
+		header.BlockSize = defaultBlockSizeKB
+	}
+	blockBytes := header.BlockSize * 1024
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal zfile header")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(zfileMagic[:])
+	writeUint32(&buf, uint32(len(headerJSON)))
+	buf.Write(headerJSON)
+
+	nBlocks := 0
+	if len(plain) > 0 {
+		nBlocks = (len(plain) + blockBytes - 1) / blockBytes
+	}
+	writeUint32(&buf, uint32(nBlocks))
+
+	blocks := make([]zfileBlock, 0, nBlocks)
+	for i := 0; i < nBlocks; i++ {
+		start := i * blockBytes
+		end := start + blockBytes
+		if end > len(plain) {
+			end = len(plain)
+		}
+		raw := plain[start:end]
+
+		packed, err := compressBytes(raw, header.Algorithm, level)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to pack zfile block %d", i)
+		}
+
+		writeUint64(&buf, uint64(len(packed)))
+		blocks = append(blocks, zfileBlock{
+			Offset:           int64(buf.Len()),
+			CompressedSize:   int64(len(packed)),
+			UncompressedSize: int64(len(raw)),
+		})
+		buf.Write(packed)
+	}
+	return buf.Bytes(), blocks, nil
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
@@ -0,0 +1,200 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"runtime"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// WithMaxParallel bounds the number of layers whose independent work
+// (reading, decrypting, zfile packing) runs concurrently. A value <= 0
+// falls back to runtime.NumCPU().
+func WithMaxParallel(n int) Option {
+	return func(o *options) {
+		o.maxParallel = n
+	}
+}
+
+// WithProgress enables per-layer mpb progress bars on os.Stderr.
+func WithProgress(enabled bool) Option {
+	return func(o *options) {
+		o.progress = enabled
+	}
+}
+
+func (o *options) parallelism() int {
+	if o.maxParallel > 0 {
+		return o.maxParallel
+	}
+	return runtime.NumCPU()
+}
+
+// layerState is the state of one layer as it moves through the worker
+// model. States are indexed by the layer's position in the manifest, not
+// by diffID; diffID is only filled in once the layer has been packed.
+type layerState struct {
+	index  int
+	desc   ocispec.Descriptor
+	bar    *mpb.Bar
+	result ocispec.Descriptor
+	diffID digest.Digest
+}
+
+// convertLayersParallel converts layers concurrently, bounded by
+// c.parallelism(), while still honoring the parent->child dependency
+// chain of overlaybd layers: layer i's zfile header can't record its
+// ParentID until layer i-1's diffID is known, so each worker waits on its
+// parent's gate before packing, even though independent work (read,
+// decrypt, stage) proceeds fully in parallel.
+func (c *conv) convertLayersParallel(ctx context.Context, cs content.Store, layers []ocispec.Descriptor) ([]ocispec.Descriptor, []digest.Digest, error) {
+	states := make([]*layerState, len(layers))
+	gates := make([]chan struct{}, len(layers)+1)
+	for i := range gates {
+		gates[i] = make(chan struct{})
+	}
+	close(gates[0])
+
+	var progress *mpb.Progress
+	if c.progress {
+		progress = mpb.New(mpb.WithWidth(64), mpb.WithOutput(os.Stderr))
+	}
+
+	sem := semaphore.NewWeighted(int64(c.parallelism()))
+	eg, ctx := errgroup.WithContext(ctx)
+
+	for i, l := range layers {
+		i, l := i, l
+		st := &layerState{index: i, desc: l}
+		states[i] = st
+		if progress != nil {
+			st.bar = progress.AddBar(l.Size,
+				mpb.PrependDecorators(decor.Name(l.Digest.String()[7:19])),
+				mpb.AppendDecorators(decor.CountersKibiByte("% .1f / % .1f"), decor.Name(" "), decor.Percentage()),
+			)
+		}
+
+		eg.Go(func() error {
+			// Independent work: read and decrypt the layer. Safe to run for
+			// every layer concurrently. Decryption happens exactly once
+			// here; the plaintext is staged as its own blob so convertLayer
+			// never has to (and never does) decrypt it again. The permit is
+			// held only for this part and released before waiting on the
+			// parent gate below — holding it across the gate wait would let
+			// a high-index layer's goroutine sit on a permit while blocked
+			// on a parent that hasn't run yet, starving layer 0 of a permit
+			// to even start its independent work and deadlocking whenever
+			// max-parallel is less than len(layers).
+			workDesc, err := func() (ocispec.Descriptor, error) {
+				if err := sem.Acquire(ctx, 1); err != nil {
+					return ocispec.Descriptor{}, err
+				}
+				defer sem.Release(1)
+
+				ra, err := cs.ReaderAt(ctx, l)
+				if err != nil {
+					return ocispec.Descriptor{}, err
+				}
+				dr, mediaType, err := c.decryptSource(ctx, l, content.NewReader(ra))
+				if err != nil {
+					ra.Close()
+					return ocispec.Descriptor{}, err
+				}
+				if mediaType == l.MediaType {
+					ra.Close()
+					return l, nil
+				}
+				data, err := ioutil.ReadAll(dr)
+				ra.Close()
+				if err != nil {
+					return ocispec.Descriptor{}, err
+				}
+				workDesc := ocispec.Descriptor{
+					MediaType: mediaType,
+					Digest:    digestOf(data),
+					Size:      int64(len(data)),
+				}
+				if err := content.WriteBlob(ctx, cs, workDesc.Digest.String(), newReader(data), workDesc); err != nil {
+					return ocispec.Descriptor{}, err
+				}
+				return workDesc, nil
+			}()
+			if err != nil {
+				return errors.Wrapf(err, "layer %s", l.Digest)
+			}
+
+			// Parent-dependent work: wait for the parent layer's diffID to
+			// be known before packing this one's zfile header.
+			select {
+			case <-gates[i]:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			if err := sem.Acquire(ctx, 1); err != nil {
+				return err
+			}
+			defer sem.Release(1)
+
+			var parentID string
+			if i > 0 {
+				parentID = states[i-1].diffID.String()
+			}
+			newDesc, diffID, err := c.convertLayer(ctx, cs, workDesc, parentID)
+			if err != nil {
+				return errors.Wrapf(err, "layer %s", l.Digest)
+			}
+			encDesc, err := c.encryptLayer(ctx, cs, *newDesc, i)
+			if err != nil {
+				return errors.Wrapf(err, "layer %s", l.Digest)
+			}
+			st.result = *encDesc
+			st.diffID = diffID
+			if st.bar != nil {
+				st.bar.SetCurrent(l.Size)
+			}
+			close(gates[i+1])
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, nil, err
+	}
+	if progress != nil {
+		progress.Wait()
+	}
+
+	result := make([]ocispec.Descriptor, len(layers))
+	diffIDs := make([]digest.Digest, len(layers))
+	for i, st := range states {
+		result[i] = st.result
+		diffIDs[i] = st.diffID
+	}
+	return result, diffIDs, nil
+}
@@ -0,0 +1,105 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// WithPlatforms restricts conversion of a manifest list to the given
+// platforms. If unset, only the platform matching the current host is
+// converted; see WithAllPlatforms to convert every manifest instead.
+func WithPlatforms(ps []ocispec.Platform) Option {
+	return func(o *options) {
+		o.platforms = ps
+	}
+}
+
+// WithAllPlatforms converts every manifest referenced by a manifest list,
+// regardless of the host platform or WithPlatforms.
+func WithAllPlatforms(all bool) Option {
+	return func(o *options) {
+		o.allPlatforms = all
+	}
+}
+
+func (c *conv) convertIndex(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	data, err := content.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read image index")
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal image index")
+	}
+
+	matcher := c.platformMatcher()
+	var selected []int
+	for i, m := range index.Manifests {
+		if m.Platform == nil || matcher.Match(*m.Platform) {
+			selected = append(selected, i)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("no manifest in the index matches the requested platform(s)")
+	}
+
+	// Manifests that didn't match the requested platform(s) are dropped
+	// from the output index rather than copied over verbatim: their blobs
+	// are never converted or staged, so keeping them would leave the
+	// pushed index with dangling references.
+	newManifests := make([]ocispec.Descriptor, len(selected))
+
+	// egCtx is cancelled once Wait returns, so the final index write below
+	// uses the caller's ctx.
+	eg, egCtx := errgroup.WithContext(ctx)
+	for pos, i := range selected {
+		pos, m := pos, index.Manifests[i]
+		eg.Go(func() error {
+			newDesc, err := c.convertManifest(egCtx, cs, m)
+			if err != nil {
+				return errors.Wrapf(err, "failed to convert manifest for platform %v", m.Platform)
+			}
+			newDesc.Platform = m.Platform
+			newManifests[pos] = *newDesc
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	index.Manifests = newManifests
+
+	return writeJSON(ctx, cs, index, desc)
+}
+
+func (c *conv) platformMatcher() platforms.Matcher {
+	if c.allPlatforms {
+		return platforms.All
+	}
+	if len(c.platforms) > 0 {
+		return platforms.Any(c.platforms...)
+	}
+	return platforms.Default()
+}
@@ -0,0 +1,45 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPlatformMatcher(t *testing.T) {
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+
+	c := &conv{options: newOptions(WithPlatforms([]ocispec.Platform{amd64}))}
+	m := c.platformMatcher()
+	if !m.Match(amd64) {
+		t.Errorf("expected matcher to match %v", amd64)
+	}
+	if m.Match(arm64) {
+		t.Errorf("expected matcher not to match %v", arm64)
+	}
+}
+
+func TestPlatformMatcherAllPlatforms(t *testing.T) {
+	c := &conv{options: newOptions(WithAllPlatforms(true), WithPlatforms([]ocispec.Platform{{OS: "linux", Architecture: "amd64"}}))}
+	m := c.platformMatcher()
+	if !m.Match(ocispec.Platform{OS: "linux", Architecture: "arm64"}) {
+		t.Error("expected WithAllPlatforms to override WithPlatforms")
+	}
+}
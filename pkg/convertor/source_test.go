@@ -0,0 +1,64 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import "testing"
+
+func TestSplitTransport(t *testing.T) {
+	for _, tc := range []struct {
+		ref           string
+		wantTransport string
+		wantRest      string
+	}{
+		{"docker.io/library/busybox:latest", "", "docker.io/library/busybox:latest"},
+		{"containerd:busybox:latest", "containerd", "busybox:latest"},
+		{"oci:/path/to/layout:tag", "oci", "/path/to/layout:tag"},
+		{"docker-archive:/path/image.tar", "docker-archive", "/path/image.tar"},
+		{"docker-daemon:busybox:latest", "docker-daemon", "busybox:latest"},
+	} {
+		transport, rest := splitTransport(tc.ref)
+		if transport != tc.wantTransport || rest != tc.wantRest {
+			t.Errorf("splitTransport(%q) = (%q, %q), want (%q, %q)",
+				tc.ref, transport, rest, tc.wantTransport, tc.wantRest)
+		}
+	}
+}
+
+func TestResolveSourceRejectsDockerDaemon(t *testing.T) {
+	_, _, err := ResolveSource("docker-daemon:busybox:latest", nil, nil)
+	if err == nil {
+		t.Fatal("expected an error for docker-daemon:, got nil")
+	}
+}
+
+func TestSplitLayoutRef(t *testing.T) {
+	for _, tc := range []struct {
+		ref     string
+		wantDir string
+		wantTag string
+	}{
+		{"/path/to/layout", "/path/to/layout", ""},
+		{"/path/to/layout:tag", "/path/to/layout", "tag"},
+		{"/path/to/layout:", "/path/to/layout", ""},
+	} {
+		dir, tag := splitLayoutRef(tc.ref)
+		if dir != tc.wantDir || tag != tc.wantTag {
+			t.Errorf("splitLayoutRef(%q) = (%q, %q), want (%q, %q)",
+				tc.ref, dir, tag, tc.wantDir, tc.wantTag)
+		}
+	}
+}
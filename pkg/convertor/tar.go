@@ -0,0 +1,75 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images/archive"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// tarSource reads an image out of a docker-archive (`docker save`) or
+// plain OCI tar, as produced by `docker save`/`ctr images export`. The
+// tar is imported, once, into a temporary content store so the rest of
+// the pipeline can treat it like any other local source.
+type tarSource struct {
+	cs       content.Store
+	imported ocispec.Descriptor
+}
+
+// newTarSource imports the tar file at path into a temporary content
+// store and returns a source backed by it.
+func newTarSource(path string) (*tarSource, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to open %q", path)
+	}
+	defer f.Close()
+
+	tmpDir, err := ioutil.TempDir("", "obdconv-tar-")
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to create temp content store")
+	}
+	cs, err := local.NewStore(tmpDir)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, "", errors.Wrap(err, "failed to open temp content store")
+	}
+
+	s := &tarSource{cs: cs}
+	s.imported, err = archive.ImportIndex(context.Background(), cs, f)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, "", errors.Wrapf(err, "failed to import %q", path)
+	}
+	return s, path, nil
+}
+
+func (s *tarSource) Resolve(ctx context.Context) (ocispec.Descriptor, error) {
+	return s.imported, nil
+}
+
+func (s *tarSource) Fetcher() remotes.Fetcher {
+	return &localStoreFetcher{store: s.cs}
+}
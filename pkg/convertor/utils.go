@@ -0,0 +1,32 @@
+/*
+   Copyright The Accelerated Container Image Authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package convertor
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func digestOf(data []byte) digest.Digest {
+	return digest.FromBytes(data)
+}
+
+func newReader(data []byte) io.Reader {
+	return bytes.NewReader(data)
+}